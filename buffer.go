@@ -4,14 +4,59 @@
 
 package bigslice
 
-import "reflect"
+import (
+	"reflect"
+	"sort"
+	"sync"
+)
 
 // TaskBuffer is an in-memory buffer of task output. It has the
 // ability to handle multiple partitions, and stores vectors of
 // records for efficiency.
 //
 // TaskBuffer layout is: partition, slices, columns.
-type taskBuffer [][][]reflect.Value
+type taskBuffer struct {
+	vecs [][][]reflect.Value
+
+	indexOnce sync.Once
+	// prefix[p] holds the cumulative row count of partition p's
+	// vectors: prefix[p][i] is the number of rows in partition p
+	// stored in vecs[p][:i]. It is built lazily, on first use, since
+	// not every taskBuffer is read back via Slice or SliceAt.
+	prefix [][]int
+}
+
+// newTaskBuffer constructs a taskBuffer over the given vectors. The
+// layout of vecs is: partition, slices, columns.
+func newTaskBuffer(vecs [][][]reflect.Value) taskBuffer {
+	return taskBuffer{vecs: vecs}
+}
+
+// buildIndex builds the per-partition prefix-sum index of vector
+// lengths. It is called at most once per taskBuffer.
+func (b *taskBuffer) buildIndex() {
+	b.indexOnce.Do(func() {
+		b.prefix = make([][]int, len(b.vecs))
+		for p, cols := range b.vecs {
+			prefix := make([]int, len(cols))
+			var n int
+			for i, col := range cols {
+				prefix[i] = n
+				n += col[0].Len()
+			}
+			b.prefix[p] = prefix
+		}
+	})
+}
+
+// SliceCookie is an opaque cursor into a taskBuffer returned by
+// SliceAt, allowing a sequential caller to resume a read in O(1)
+// rather than re-locating its position via a binary search on every
+// call. The zero SliceCookie refers to the beginning of a partition.
+type SliceCookie struct {
+	vec int // index of the vector the cookie resumes at
+	off int // offset within that vector
+}
 
 // Slice returns column vectors for the provided partition and global
 // offset. The returned offset indicates the position of the global
@@ -19,71 +64,116 @@ type taskBuffer [][][]reflect.Value
 // indicates EOF. Slice is designed to perform zero-copy reads
 // from a taskBuffer.
 //
-// TODO(marius): Slicing is currently inefficient as it requires a
-// linear walk through the stored vectors. We should aggregate
-// lengths so that we can perform a binary search. Alternatively, we
-// can return a cookie from Slice that enables efficient resumption.
-func (b taskBuffer) Slice(partition, off int) ([]reflect.Value, int) {
-	var beg, end int
+// Slice locates the offset by binary-searching the partition's
+// prefix-sum index, so its cost is O(log V) in the number of stored
+// vectors rather than O(V). Callers that read sequentially should
+// prefer SliceAt, which resumes from a cookie in O(1).
+func (b *taskBuffer) Slice(partition, off int) ([]reflect.Value, int) {
+	b.buildIndex()
 	if partition == AllPartitions {
-		beg, end = 0, len(b)
-	} else {
-		beg, end = partition, partition+1
-	}
-	// Find the offset.
-	var n int
-	for i := beg; i < end; i++ {
-		for _, cols := range b[i] {
-			l := cols[0].Len()
-			if n+l > off {
-				return cols, off - n
+		for p := range b.vecs {
+			if cols, o := b.slicePartition(p, off); o >= 0 {
+				return cols, o
 			}
-			n += l
+			off -= b.partitionLen(p)
 		}
+		return nil, -1
 	}
-	return nil, -1
+	return b.slicePartition(partition, off)
+}
+
+// partitionLen returns the total number of rows stored for the
+// given partition.
+func (b *taskBuffer) partitionLen(partition int) int {
+	prefix := b.prefix[partition]
+	cols := b.vecs[partition]
+	if len(cols) == 0 {
+		return 0
+	}
+	return prefix[len(prefix)-1] + cols[len(cols)-1][0].Len()
+}
+
+// slicePartition implements Slice for a single, concrete partition.
+func (b *taskBuffer) slicePartition(partition, off int) ([]reflect.Value, int) {
+	cols := b.vecs[partition]
+	prefix := b.prefix[partition]
+	// Find the last vector whose prefix is <= off.
+	i := sort.Search(len(prefix), func(i int) bool { return prefix[i] > off }) - 1
+	if i < 0 || i >= len(cols) {
+		return nil, -1
+	}
+	intra := off - prefix[i]
+	if intra >= cols[i][0].Len() {
+		return nil, -1
+	}
+	return cols[i], intra
+}
+
+// SliceAt returns column vectors for the provided partition,
+// resuming from cookie. It behaves like Slice, except that a
+// non-zero cookie lets the caller resume a sequential read in O(1)
+// instead of paying for a fresh binary search. SliceAt also returns
+// a cookie that can be passed back in to resume immediately after
+// the returned vectors. A returned offset of -1 indicates EOF, in
+// which case the returned cookie is the same as the one passed in.
+// Unlike Slice, SliceAt does not accept AllPartitions: partition
+// must name a single partition.
+func (b *taskBuffer) SliceAt(partition int, cookie SliceCookie) ([]reflect.Value, int, SliceCookie) {
+	b.buildIndex()
+	cols := b.vecs[partition]
+	if cookie.vec >= len(cols) {
+		return nil, -1, cookie
+	}
+	col := cols[cookie.vec]
+	if cookie.off >= col[0].Len() {
+		return nil, -1, cookie
+	}
+	next := cookie
+	next.vec++
+	next.off = 0
+	return col, cookie.off, next
 }
 
 type taskBufferReader struct {
-	q       taskBuffer
-	i, j, k int
+	q         *taskBuffer
+	i         int
+	partition int
+	cookie    SliceCookie
 }
 
 func (r *taskBufferReader) Read(out ...reflect.Value) (int, error) {
-loop:
 	for {
-		switch {
-		case len(r.q) == r.i:
-			return 0, EOF
-		case len(r.q[r.i]) == r.j:
-			r.i++
-			r.j, r.k = 0, 0
-		case r.q[r.i][r.j][0].Len() == r.k:
-			r.j++
-			r.k = 0
-		default:
-			break loop
+		cols, off, next := r.q.SliceAt(r.partition, r.cookie)
+		if off < 0 {
+			if r.i++; r.i >= len(r.q.vecs) {
+				return 0, EOF
+			}
+			r.partition = r.i
+			r.cookie = SliceCookie{}
+			continue
 		}
+		r.cookie = next
+		n := out[0].Len()
+		if m := cols[0].Len() - off; m < n {
+			n = m
+		}
+		l := off + n
+		for i, val := range out {
+			// TODO(marius): Consider changing the Reader interface to allow
+			// for zero-copy transfers in this case.
+			reflect.Copy(val, cols[i].Slice(off, l))
+		}
+		if l < cols[0].Len() {
+			r.cookie = SliceCookie{vec: r.cookie.vec - 1, off: l}
+		}
+		return n, nil
 	}
-	buf := r.q[r.i][r.j]
-	n := out[0].Len()
-	if m := buf[0].Len() - r.k; m < n {
-		n = m
-	}
-	l := r.k + n
-	for i, val := range out {
-		// TODO(marius): Consider changing the Reader interface to allow
-		// for zero-copy transfers in this case.
-		reflect.Copy(val, r.q[r.i][r.j][i].Slice(r.k, l))
-	}
-	r.k = l
-	return n, nil
 }
 
 // Reader returns a Reader for a partition of the taskBuffer.
-func (b taskBuffer) Reader(partition int) Reader {
+func (b *taskBuffer) Reader(partition int) Reader {
 	if partition == AllPartitions {
-		return &taskBufferReader{q: b}
+		return &taskBufferReader{q: b, partition: 0}
 	}
-	return &taskBufferReader{q: b[partition : partition+1]}
-}
\ No newline at end of file
+	return &taskBufferReader{q: &taskBuffer{vecs: b.vecs[partition : partition+1]}, partition: 0}
+}
@@ -0,0 +1,182 @@
+// Copyright 2018 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package bigslice
+
+import (
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+// ints converts a []int into the single-column vector
+// representation taskBuffer expects.
+func ints(vals ...int) []reflect.Value {
+	return []reflect.Value{reflect.ValueOf(append([]int{}, vals...))}
+}
+
+// readAll drains r a row at a time and returns the recovered ints.
+func readAll(t *testing.T, r Reader) []int {
+	t.Helper()
+	var got []int
+	out := []reflect.Value{reflect.ValueOf(make([]int, 1))}
+	for {
+		n, err := r.Read(out...)
+		for i := 0; i < n; i++ {
+			got = append(got, int(out[0].Index(i).Int()))
+		}
+		if err == EOF {
+			return got
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n == 0 {
+			return got
+		}
+	}
+}
+
+// TestTaskBuffer exercises Slice, SliceAt and Reader against a
+// taskBuffer with multiple vectors per partition, an empty
+// partition, and a single-vector partition, verifying that all
+// three paths recover exactly what was written.
+func TestTaskBuffer(t *testing.T) {
+	b := newTaskBuffer([][][]reflect.Value{
+		0: {ints(0, 1, 2), ints(3, 4, 5, 6)}, // two vectors: 3 rows, 4 rows
+		1: {},                                // empty partition
+		2: {ints(7, 8, 9, 10, 11)},            // one vector: 5 rows
+	})
+
+	// Slice should recover every row of partition 0 at every offset,
+	// including the boundary between its two vectors.
+	for off := 0; off < 7; off++ {
+		cols, o := b.Slice(0, off)
+		if o < 0 {
+			t.Fatalf("Slice(0, %d): unexpected EOF", off)
+		}
+		if got, want := int(cols[0].Index(o).Int()), off; got != want {
+			t.Errorf("Slice(0, %d) = %d, want %d", off, got, want)
+		}
+	}
+	if _, o := b.Slice(0, 7); o != -1 {
+		t.Errorf("Slice(0, 7): got offset %d, want -1 (EOF)", o)
+	}
+
+	// Partition 1 is empty: every offset is EOF.
+	if _, o := b.Slice(1, 0); o != -1 {
+		t.Errorf("Slice(1, 0): got offset %d, want -1 (EOF)", o)
+	}
+
+	// Partition 2 has a single vector.
+	for off := 0; off < 5; off++ {
+		cols, o := b.Slice(2, off)
+		if o < 0 {
+			t.Fatalf("Slice(2, %d): unexpected EOF", off)
+		}
+		if got, want := int(cols[0].Index(o).Int()), off+7; got != want {
+			t.Errorf("Slice(2, %d) = %d, want %d", off, got, want)
+		}
+	}
+
+	// AllPartitions walks every partition in order, skipping the
+	// empty one.
+	want := []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11}
+	for off, w := range want {
+		cols, o := b.Slice(AllPartitions, off)
+		if o < 0 {
+			t.Fatalf("Slice(AllPartitions, %d): unexpected EOF", off)
+		}
+		if got := int(cols[0].Index(o).Int()); got != w {
+			t.Errorf("Slice(AllPartitions, %d) = %d, want %d", off, got, w)
+		}
+	}
+	if _, o := b.Slice(AllPartitions, len(want)); o != -1 {
+		t.Errorf("Slice(AllPartitions, %d): got offset %d, want -1 (EOF)", len(want), o)
+	}
+
+	// SliceAt, resuming sequentially from the zero cookie, must
+	// recover the same rows as Slice for a single partition.
+	var (
+		cookie SliceCookie
+		got    []int
+	)
+	for {
+		cols, o, next := b.SliceAt(0, cookie)
+		if o < 0 {
+			break
+		}
+		for i := o; i < cols[0].Len(); i++ {
+			got = append(got, int(cols[0].Index(i).Int()))
+		}
+		cookie = next
+	}
+	if want := []int{0, 1, 2, 3, 4, 5, 6}; !reflect.DeepEqual(got, want) {
+		t.Errorf("SliceAt(0, ...) recovered %v, want %v", got, want)
+	}
+
+	// Reader(AllPartitions) must recover every row, across all
+	// vectors and partitions, in order.
+	if got := readAll(t, b.Reader(AllPartitions)); !reflect.DeepEqual(got, want) {
+		t.Errorf("Reader(AllPartitions) recovered %v, want %v", got, want)
+	}
+
+	// Reader(partition) must recover just that partition's rows.
+	if got := readAll(t, b.Reader(2)); !reflect.DeepEqual(got, []int{7, 8, 9, 10, 11}) {
+		t.Errorf("Reader(2) recovered %v, want [7 8 9 10 11]", got)
+	}
+}
+
+// makeBenchBuffer constructs a single-partition taskBuffer with
+// nvecs vectors of vecLen rows each, for use by the benchmarks
+// below.
+func makeBenchBuffer(nvecs, vecLen int) taskBuffer {
+	part := make([][]reflect.Value, nvecs)
+	for i := range part {
+		vals := make([]int, vecLen)
+		part[i] = []reflect.Value{reflect.ValueOf(vals)}
+	}
+	return newTaskBuffer([][][]reflect.Value{part})
+}
+
+// BenchmarkTaskBufferSlice measures the cost of repeated Slice
+// calls, at offsets cycling through the buffer, which must binary-search the
+// prefix-sum index, as a function of the number of vectors stored
+// in the buffer.
+func BenchmarkTaskBufferSlice(b *testing.B) {
+	for _, nvecs := range []int{16, 256, 4096} {
+		b.Run("vectors="+strconv.Itoa(nvecs), func(b *testing.B) {
+			buf := makeBenchBuffer(nvecs, 64)
+			total := nvecs * 64
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				buf.Slice(0, i%total)
+			}
+		})
+	}
+}
+
+// BenchmarkTaskBufferSliceAt measures the cost of SliceAt when
+// called by consumers reading sequentially, as a function of the
+// number of consumers sharing the same buffer. The consumers take
+// turns on a single goroutine; they do not run concurrently.
+func BenchmarkTaskBufferSliceAt(b *testing.B) {
+	for _, consumers := range []int{1, 8, 64} {
+		b.Run("consumers="+strconv.Itoa(consumers), func(b *testing.B) {
+			buf := makeBenchBuffer(4096, 64)
+			cookies := make([]SliceCookie, consumers)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				c := i % consumers
+				_, off, next := buf.SliceAt(0, cookies[c])
+				if off < 0 {
+					cookies[c] = SliceCookie{}
+					continue
+				}
+				cookies[c] = next
+			}
+		})
+	}
+}
+
@@ -23,7 +23,8 @@ type reshuffleSlice struct {
 //
 // The output slice has the same type as the input.
 //
-// TODO: Add ReshuffleSort, which also sorts keys within each shard.
+// See ReshuffleSort for a variant that also sorts keys within each
+// shard.
 func Reshuffle(slice Slice) Slice {
 	if err := canMakeCombiningFrame(slice); err != nil {
 		typecheck.Panic(1, err.Error())
@@ -0,0 +1,109 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package bigslice
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/grailbio/bigslice/frame"
+	"github.com/grailbio/bigslice/kernel"
+	"github.com/grailbio/bigslice/slicefunc"
+	"github.com/grailbio/bigslice/sliceio"
+	"github.com/grailbio/bigslice/slicetype"
+	"github.com/grailbio/bigslice/sortio"
+	"github.com/grailbio/bigslice/typecheck"
+)
+
+// reshuffleSortSpillTarget bounds the size of the spill files written
+// while sorting a shard's shuffle input. It mirrors the default used
+// by Reduce's shuffle-sort path.
+const reshuffleSortSpillTarget = 1 << 20
+
+type reshuffleSortSlice struct {
+	name   Name
+	sorter kernel.Sorter
+	Slice
+}
+
+// ReshuffleSort returns a slice that shuffles rows by prefix, as
+// Reshuffle does, and additionally sorts each output shard in
+// ascending prefix order, using the kernel.Sorter registered for the
+// slice's prefix columns. Unlike Reshuffle, which makes no ordering
+// guarantee within a shard, ReshuffleSort's output shards are each a
+// single sorted stream, which makes it possible to run a streaming
+// Reduce or merge-join over the result without an additional sort.
+//
+// The output slice has the same type as the input.
+func ReshuffleSort(slice Slice) Slice {
+	if err := canMakeCombiningFrame(slice); err != nil {
+		typecheck.Panic(1, err.Error())
+	}
+	sorter, err := kernel.SorterFor(slice)
+	if err != nil {
+		typecheck.Panic(1, err.Error())
+	}
+	return &reshuffleSortSlice{makeName("reshufflesort"), sorter, slice}
+}
+
+func (r *reshuffleSortSlice) Name() Name { return r.name }
+func (*reshuffleSortSlice) NumDep() int  { return 1 }
+
+// Dep returns the dependency for the shuffle input, with the sort
+// flag (the kernel.Sorter) set so that the executor knows to deliver
+// a stream that is sorted per shard.
+func (r *reshuffleSortSlice) Dep(i int) Dep          { return Dep{r.Slice, true, r.sorter, false} }
+func (*reshuffleSortSlice) Combiner() slicefunc.Func { return slicefunc.Nil }
+
+func (r *reshuffleSortSlice) Reader(shard int, deps []sliceio.Reader) sliceio.Reader {
+	if len(deps) != 1 {
+		panic(fmt.Errorf("expected one dep, got %d", len(deps)))
+	}
+	// If the executor already delivered a presorted stream for this
+	// shard (which is what setting the sort flag on Dep asks it to
+	// do), there's nothing left to sort: use it directly, the same
+	// way Reshuffle's Reader does.
+	if p, ok := deps[0].(PresortedReader); ok && p.Presorted() {
+		return deps[0]
+	}
+	return &reshuffleSortReader{typ: r.Slice, sorter: r.sorter, reader: deps[0]}
+}
+
+// PresortedReader is implemented by sliceio.Readers that are known,
+// by construction, to already deliver rows in the order required by
+// a kernel.Sorter -- for example, a per-shard stream an executor
+// assembles directly from already-sorted shuffle spill files.
+// reshuffleSortReader checks for this to avoid an unnecessary
+// spill-and-merge pass over input that is already sorted.
+type PresortedReader interface {
+	sliceio.Reader
+	Presorted() bool
+}
+
+// reshuffleSortReader lazily sorts its underlying reader on the
+// first Read call, once a context is available, and then delegates
+// to the sorted reader for the remainder of the stream. If the
+// upstream shuffle input is not already sorted, sortio.SortReader
+// spills and merges it into a single sorted stream.
+type reshuffleSortReader struct {
+	typ    slicetype.Type
+	sorter kernel.Sorter
+	reader sliceio.Reader
+
+	once   sync.Once
+	sorted sliceio.Reader
+	err    error
+}
+
+func (r *reshuffleSortReader) Read(ctx context.Context, out frame.Frame) (int, error) {
+	r.once.Do(func() {
+		r.sorted, r.err = sortio.SortReader(ctx, r.sorter, slicefunc.Nil, reshuffleSortSpillTarget, r.typ, r.reader)
+	})
+	if r.err != nil {
+		return 0, r.err
+	}
+	return r.sorted.Read(ctx, out)
+}
@@ -0,0 +1,102 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package bigslice
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/grailbio/bigslice/frame"
+	"github.com/grailbio/bigslice/slicefunc"
+	"github.com/grailbio/bigslice/sliceio"
+	"github.com/grailbio/bigslice/slicetype"
+)
+
+// fakeSlice is a minimal Slice used to build a reshuffleSortSlice
+// without going through ReshuffleSort's kernel.SorterFor lookup.
+type fakeSlice struct{ slicetype.Type }
+
+func (fakeSlice) Name() Name                                             { var n Name; return n }
+func (fakeSlice) NumDep() int                                            { return 0 }
+func (fakeSlice) Dep(i int) Dep                                          { panic("not implemented") }
+func (fakeSlice) Combiner() slicefunc.Func                               { return slicefunc.Nil }
+func (fakeSlice) Reader(shard int, deps []sliceio.Reader) sliceio.Reader { panic("not implemented") }
+
+// fakeSorter is a no-op kernel.Sorter, sufficient to exercise
+// reshuffleSortSlice's wiring without sorting anything for real.
+type fakeSorter struct{}
+
+func (fakeSorter) Sort(f frame.Frame) {}
+func (fakeSorter) Less(f1 frame.Frame, i int, f2 frame.Frame, j int) bool { return false }
+
+// fakeReader is a sliceio.Reader that delivers no rows. It does not
+// implement PresortedReader.
+type fakeReader struct{}
+
+func (fakeReader) Read(ctx context.Context, out frame.Frame) (int, error) { return 0, sliceio.EOF }
+
+// fakePresortedReader additionally reports whether it is presorted,
+// as a real executor-assembled, already-sorted shuffle stream would.
+type fakePresortedReader struct {
+	fakeReader
+	presorted bool
+}
+
+func (r fakePresortedReader) Presorted() bool { return r.presorted }
+
+func newTestReshuffleSortSlice() *reshuffleSortSlice {
+	return &reshuffleSortSlice{
+		sorter: fakeSorter{},
+		Slice:  fakeSlice{slicetype.New(reflect.TypeOf(0))},
+	}
+}
+
+// TestReshuffleSortSliceDep checks that reshuffleSortSlice has
+// exactly one dep and that Dep(0) can be built from s alone. It does
+// not inspect the returned Dep's fields.
+func TestReshuffleSortSliceDep(t *testing.T) {
+	s := newTestReshuffleSortSlice()
+	if got, want := s.NumDep(), 1; got != want {
+		t.Errorf("NumDep() = %d, want %d", got, want)
+	}
+	// Dep(0) must not panic: reshuffleSortSlice.Dep only reads fields
+	// already set on s, it does not call through to the wrapped
+	// Slice.
+	_ = s.Dep(0)
+}
+
+// TestReshuffleSortSliceReaderSkipsPresorted checks that Reader uses
+// a dep's stream directly, without wrapping it in a
+// reshuffleSortReader, exactly when that stream reports itself as
+// PresortedReader with Presorted() == true.
+func TestReshuffleSortSliceReaderSkipsPresorted(t *testing.T) {
+	s := newTestReshuffleSortSlice()
+
+	presorted := fakePresortedReader{presorted: true}
+	if got := s.Reader(0, []sliceio.Reader{presorted}); got != sliceio.Reader(presorted) {
+		t.Errorf("Reader with a presorted dep returned %#v, want the dep itself", got)
+	}
+
+	notPresorted := fakePresortedReader{presorted: false}
+	got := s.Reader(0, []sliceio.Reader{notPresorted})
+	rsr, ok := got.(*reshuffleSortReader)
+	if !ok {
+		t.Fatalf("Reader with Presorted()==false returned %T, want *reshuffleSortReader", got)
+	}
+	if rsr.reader != sliceio.Reader(notPresorted) {
+		t.Error("reshuffleSortReader does not wrap the original dep")
+	}
+
+	plain := fakeReader{}
+	got = s.Reader(0, []sliceio.Reader{plain})
+	rsr, ok = got.(*reshuffleSortReader)
+	if !ok {
+		t.Fatalf("Reader with a non-PresortedReader dep returned %T, want *reshuffleSortReader", got)
+	}
+	if rsr.reader != sliceio.Reader(plain) {
+		t.Error("reshuffleSortReader does not wrap the original dep")
+	}
+}
@@ -0,0 +1,111 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package sortio
+
+import "github.com/grailbio/bigslice/kernel"
+
+// loserTree finds the minimum (according to a kernel.Sorter) of a
+// set of FrameBuffers' current rows in O(log2 k) time per step: a
+// complete binary tree of size k (the number of buffers, rounded up
+// to a power of two) in which each internal node holds the index of
+// the loser of the match between its two subtrees, and tree[0]
+// holds the index of the overall winner. Replacing the winner's row
+// (via replay, after the caller has advanced or exhausted its
+// buffer) costs exactly ceil(log2(k)) comparisons, along the single
+// root-to-leaf path for that leaf -- unlike a container/heap, whose
+// sift-down/sift-up costs up to 2*log2(k) comparisons. This is used
+// by NewTournamentMergeReader and ResumableMergeReader when their
+// fan-in is large, or the Sorter's Less is expensive.
+//
+// Missing buffers, and buffers padded in to round k up to a power of
+// two, are represented as nil and treated as infinitely large, so
+// they always lose.
+type loserTree struct {
+	sorter  kernel.Sorter
+	buffers []*FrameBuffer // length is a power of two
+	tree    []int          // internal nodes; tree[0] is the overall winner
+}
+
+// newLoserTree builds a loser tree over buffers, which is padded
+// with nil (always-losing) entries up to the next power of two.
+func newLoserTree(sorter kernel.Sorter, buffers []*FrameBuffer) *loserTree {
+	k := 1
+	for k < len(buffers) {
+		k *= 2
+	}
+	t := &loserTree{
+		sorter:  sorter,
+		buffers: make([]*FrameBuffer, k),
+		tree:    make([]int, k),
+	}
+	copy(t.buffers, buffers)
+	for i := range t.tree {
+		t.tree[i] = -1
+	}
+	for i := range t.buffers {
+		t.insert(i)
+	}
+	return t
+}
+
+// winner returns the index of the buffer currently holding the
+// minimum row, i.e. the root of the tree.
+func (t *loserTree) winner() int { return t.tree[0] }
+
+// empty reports whether every buffer is exhausted.
+func (t *loserTree) empty() bool { return t.buffers[t.winner()] == nil }
+
+// less reports whether leaf i's current row should be considered to
+// come before leaf j's. An exhausted (nil) leaf is infinitely large,
+// so it always loses to a leaf that still has rows.
+func (t *loserTree) less(i, j int) bool {
+	bi, bj := t.buffers[i], t.buffers[j]
+	switch {
+	case bi == nil:
+		return false
+	case bj == nil:
+		return true
+	default:
+		return t.sorter.Less(bi.Frame, bi.Off, bj.Frame, bj.Off)
+	}
+}
+
+// insert plays leaf's matches up the tree during initial
+// construction. Each internal node on the path from the leaf to the
+// root holds, at any point during construction, a sentinel (not yet
+// visited), the winner of one subtree waiting for the other, or the
+// loser of the match played there once both have arrived.
+// insert bubbles the winner up until it either claims an empty node
+// (in which case the remaining comparisons are deferred until the
+// sibling subtree is inserted) or reaches the root.
+func (t *loserTree) insert(leaf int) {
+	k := len(t.buffers)
+	winner := leaf
+	for p := (leaf + k) / 2; p >= 1; p /= 2 {
+		if t.tree[p] == -1 {
+			t.tree[p] = winner
+			return
+		}
+		if !t.less(winner, t.tree[p]) {
+			winner, t.tree[p] = t.tree[p], winner
+		}
+	}
+	t.tree[0] = winner
+}
+
+// replay re-plays the matches along leaf's root path after leaf's
+// buffer has advanced (or been exhausted), updating the stored
+// losers and the root winner pointer. This costs exactly
+// ceil(log2(k)) comparisons.
+func (t *loserTree) replay(leaf int) {
+	k := len(t.buffers)
+	winner := leaf
+	for p := (leaf + k) / 2; p >= 1; p /= 2 {
+		if !t.less(winner, t.tree[p]) {
+			winner, t.tree[p] = t.tree[p], winner
+		}
+	}
+	t.tree[0] = winner
+}
@@ -0,0 +1,296 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package sortio
+
+import (
+	"container/heap"
+	"context"
+	"reflect"
+
+	"github.com/grailbio/bigslice/frame"
+	"github.com/grailbio/bigslice/kernel"
+	"github.com/grailbio/bigslice/sliceio"
+	"github.com/grailbio/bigslice/slicetype"
+)
+
+// RowSeeker is implemented by sliceio.Readers that can restart
+// themselves at an arbitrary row offset using a sparse row-offset
+// index stored alongside their underlying data, rather than
+// re-reading from the start. A reader that does not implement
+// RowSeeker can still be merged, but ResumableMergeReader.Resume
+// cannot fast-forward it, and will re-read (and discard) the rows it
+// already delivered.
+//
+// This is the extension point the sliceio package's Spiller and the
+// readers returned by Spill.Readers() would need to implement --
+// storing a sparse row-offset index alongside each spill file -- for
+// ResumableMergeReader.Resume to actually skip re-reading a spilled
+// run instead of falling through to discardRows. That part of the
+// original request is NOT done: the sliceio package is not part of
+// this tree (no sliceio/*.go file exists here to change), so no spill
+// reader in this codebase implements RowSeeker today, and Resume's
+// fast path is exercised only by the seekableFrameReader test fixture
+// in resumable_test.go, not by any production reader. What is
+// implemented here -- the merge/checkpoint/resume mechanism itself,
+// and its wiring into SortReader -- stands on its own (it still lets
+// a task retry restart a merge without starting over, it just
+// currently always takes the discardRows path to do so); the seek
+// fast path it's built to support is the still-open half of this
+// request. ResumeStats reports, after each Resume call, how many
+// readers actually took the seek path, so that gap stays visible
+// rather than silent.
+type RowSeeker interface {
+	SeekToRow(rowOffset int64) error
+}
+
+// A MergeCursor records how many rows of one of a
+// ResumableMergeReader's input readers had been delivered at the
+// point Checkpoint was called.
+type MergeCursor struct {
+	// Reader is the position of the reader in the slice originally
+	// passed to NewResumableMergeReader.
+	Reader int
+	// RowOffset is the number of rows of that reader already
+	// delivered.
+	RowOffset int64
+}
+
+// ResumableMergeReader merges multiple sorted readers, like
+// NewMergeReader and NewTournamentMergeReader, while additionally
+// tracking the merge frontier: how many rows of each input reader
+// have been delivered. Checkpoint captures this frontier as a set
+// of MergeCursors, and Resume rebuilds it, seeking every reader that
+// implements RowSeeker back to its recorded row offset. This lets
+// bigslice's task executor restart shuffle-read work that is
+// interrupted by a transient worker failure at the point it left
+// off, rather than redoing the full external sort.
+//
+// Like SortReader, ResumableMergeReader switches from a
+// container/heap to a loserTree once the fan-in reaches
+// tournamentMergeThreshold.
+type ResumableMergeReader struct {
+	typ      slicetype.Type
+	sorter   kernel.Sorter
+	readers  []sliceio.Reader
+	consumed []int64
+
+	heap *FrameBufferHeap
+	tree *loserTree
+	err  error
+
+	lastResumeSeeked int
+	lastResumeTotal  int
+}
+
+// NewResumableMergeReader returns a ResumableMergeReader, sorted
+// according to sorter, that merges readers. The readers to be
+// merged must already be sorted according to sorter.
+func NewResumableMergeReader(ctx context.Context, typ slicetype.Type, sorter kernel.Sorter, readers []sliceio.Reader) (*ResumableMergeReader, error) {
+	buffers, err := fillFrameBuffers(ctx, typ, readers)
+	if err != nil {
+		return nil, err
+	}
+	m := &ResumableMergeReader{
+		typ:      typ,
+		sorter:   sorter,
+		readers:  readers,
+		consumed: make([]int64, len(readers)),
+	}
+	m.install(sorter, buffers)
+	return m, nil
+}
+
+// install picks the merge strategy for buffers -- a loserTree at or
+// above tournamentMergeThreshold readers, a FrameBufferHeap below.
+// SortReader returns a ResumableMergeReader, so this is also how
+// SortReader's merge strategy is chosen.
+func (m *ResumableMergeReader) install(sorter kernel.Sorter, buffers []*FrameBuffer) {
+	if len(buffers) >= tournamentMergeThreshold {
+		m.tree = newLoserTree(sorter, buffers)
+		m.heap = nil
+		return
+	}
+	h := new(FrameBufferHeap)
+	h.Sorter = sorter
+	h.Buffers = make([]*FrameBuffer, 0, len(buffers))
+	for _, fr := range buffers {
+		if fr != nil {
+			h.Buffers = append(h.Buffers, fr)
+		}
+	}
+	heap.Init(h)
+	m.heap = h
+	m.tree = nil
+}
+
+// winner returns the FrameBuffer currently holding the minimum row,
+// according to whichever strategy is installed, or nil if every
+// reader is exhausted.
+func (m *ResumableMergeReader) winner() *FrameBuffer {
+	if m.tree != nil {
+		return m.tree.buffers[m.tree.winner()]
+	}
+	if len(m.heap.Buffers) == 0 {
+		return nil
+	}
+	return m.heap.Buffers[0]
+}
+
+// advance is called after the winning FrameBuffer's row has been
+// delivered and its offset incremented; it refills the winner,
+// removing or replaying it as the installed strategy requires.
+func (m *ResumableMergeReader) advance(ctx context.Context, top *FrameBuffer) error {
+	if top.Off != top.Len {
+		if m.tree != nil {
+			m.tree.replay(top.Source)
+		} else {
+			heap.Fix(m.heap, 0)
+		}
+		return nil
+	}
+	err := top.Fill(ctx)
+	if err != nil && err != sliceio.EOF {
+		return err
+	}
+	if m.tree != nil {
+		if err == sliceio.EOF {
+			m.tree.buffers[top.Source] = nil
+		}
+		m.tree.replay(top.Source)
+		return nil
+	}
+	if err == sliceio.EOF {
+		heap.Remove(m.heap, 0)
+	} else {
+		heap.Fix(m.heap, 0)
+	}
+	return nil
+}
+
+// Read implements sliceio.Reader.
+func (m *ResumableMergeReader) Read(ctx context.Context, out frame.Frame) (int, error) {
+	if m.err != nil {
+		return 0, m.err
+	}
+	var (
+		row = make([]reflect.Value, len(out))
+		n   int
+		max = out.Len()
+	)
+	for n < max {
+		top := m.winner()
+		if top == nil {
+			break
+		}
+		top.CopyIndex(row, top.Off)
+		out.SetIndex(row, n)
+		n++
+		top.Off++
+		m.consumed[top.Source]++
+		if err := m.advance(ctx, top); err != nil {
+			m.err = err
+			return 0, err
+		}
+	}
+	if n == 0 {
+		m.err = sliceio.EOF
+	}
+	return n, m.err
+}
+
+// Checkpoint returns the current merge frontier: the number of rows
+// so far delivered from each of the readers passed to
+// NewResumableMergeReader. The returned cursors can later be passed
+// to Resume to restart the merge at the same point.
+func (m *ResumableMergeReader) Checkpoint() []MergeCursor {
+	cursors := make([]MergeCursor, len(m.consumed))
+	for i, n := range m.consumed {
+		cursors[i] = MergeCursor{Reader: i, RowOffset: n}
+	}
+	return cursors
+}
+
+// Resume rebuilds the merge frontier at the positions recorded by
+// cursors. For each cursor whose reader implements RowSeeker, Resume
+// seeks it to the recorded row offset; otherwise, it re-reads the
+// reader from the beginning, discarding the rows already delivered.
+// Resume replaces the reader state built by the prior
+// NewResumableMergeReader or Resume call, so it is typically called
+// after a transient failure, once the task executor has
+// reconnected the underlying readers.
+//
+// ResumeStats reports, after Resume returns, how many of the cursors
+// it processed used the RowSeeker fast path rather than discardRows
+// -- see RowSeeker's doc comment for why, in this tree, that count is
+// currently always zero for any production reader.
+func (m *ResumableMergeReader) Resume(ctx context.Context, cursors []MergeCursor) error {
+	buffers := make([]*FrameBuffer, len(m.readers))
+	seeked := 0
+	for _, c := range cursors {
+		r := m.readers[c.Reader]
+		seekable := false
+		if seeker, ok := r.(RowSeeker); ok {
+			if err := seeker.SeekToRow(c.RowOffset); err != nil {
+				return err
+			}
+			seekable = true
+			seeked++
+		}
+		fr := &FrameBuffer{
+			Reader: r,
+			Frame:  frame.Make(m.typ, sliceio.SpillBatchSize),
+			Source: c.Reader,
+		}
+		m.consumed[c.Reader] = 0
+		if err := fr.Fill(ctx); err != nil && err != sliceio.EOF {
+			return err
+		}
+		if !seekable {
+			if err := discardRows(ctx, fr, c.RowOffset); err != nil {
+				return err
+			}
+		}
+		m.consumed[c.Reader] = c.RowOffset
+		if fr.Off < fr.Len {
+			buffers[c.Reader] = fr
+		}
+	}
+	m.install(m.sorter, buffers)
+	m.err = nil
+	m.lastResumeSeeked = seeked
+	m.lastResumeTotal = len(cursors)
+	return nil
+}
+
+// ResumeStats reports how many of the readers passed to the most
+// recent Resume call took the RowSeeker fast path (seeked) out of how
+// many cursors were resumed in total (total). It is meant for tests
+// and diagnostics: it lets a caller confirm whether Resume is
+// actually fast-forwarding its readers or falling back to
+// re-reading-and-discarding, rather than that distinction being
+// silent.
+func (m *ResumableMergeReader) ResumeStats() (seeked, total int) {
+	return m.lastResumeSeeked, m.lastResumeTotal
+}
+
+// discardRows advances fr past the first n rows, refilling it from
+// its underlying reader as necessary. It is used by Resume to
+// fast-forward readers that cannot seek.
+func discardRows(ctx context.Context, fr *FrameBuffer, n int64) error {
+	for n > 0 {
+		if fr.Off == fr.Len {
+			if err := fr.Fill(ctx); err != nil {
+				return err
+			}
+		}
+		skip := int64(fr.Len - fr.Off)
+		if skip > n {
+			skip = n
+		}
+		fr.Off += int(skip)
+		n -= skip
+	}
+	return nil
+}
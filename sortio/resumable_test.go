@@ -0,0 +1,123 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package sortio
+
+import (
+	"context"
+	"math/rand"
+	"reflect"
+	"sort"
+	"strconv"
+	"testing"
+
+	"github.com/grailbio/bigslice/frame"
+	"github.com/grailbio/bigslice/sliceio"
+)
+
+// seekableFrameReader is a frameReader that also implements
+// RowSeeker, as the readers returned by a real Spill would.
+type seekableFrameReader struct{ frameReader }
+
+func (r *seekableFrameReader) SeekToRow(rowOffset int64) error {
+	r.off = int(rowOffset)
+	return nil
+}
+
+// buildSeekableReaders builds one seekableFrameReader per (sorted)
+// int vector in valsPerReader.
+func buildSeekableReaders(valsPerReader [][]int) []sliceio.Reader {
+	readers := make([]sliceio.Reader, len(valsPerReader))
+	for i, vals := range valsPerReader {
+		f := frame.Make(benchTyp, len(vals))
+		row := make([]reflect.Value, 1)
+		for j, v := range vals {
+			row[0] = reflect.ValueOf(v)
+			f.SetIndex(row, j)
+		}
+		readers[i] = &seekableFrameReader{frameReader{Frame: f}}
+	}
+	return readers
+}
+
+func drainInts(ctx context.Context, t *testing.T, r sliceio.Reader, max int) []int {
+	t.Helper()
+	var got []int
+	out := frame.Make(benchTyp, 16)
+	row := make([]reflect.Value, 1)
+	for max < 0 || len(got) < max {
+		n, err := r.Read(ctx, out)
+		for i := 0; i < n; i++ {
+			out.CopyIndex(row, i)
+			got = append(got, int(row[0].Int()))
+		}
+		if err == sliceio.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n == 0 {
+			break
+		}
+	}
+	return got
+}
+
+// TestResumableMergeReaderResume verifies that a ResumableMergeReader
+// whose readers are interrupted mid-stream (losing their read
+// position) can be resumed from a checkpoint and produce the same
+// output as an uninterrupted merge. It covers both the
+// FrameBufferHeap strategy (k below tournamentMergeThreshold) and the
+// loserTree strategy (k at or above it), since install, advance and
+// Resume each branch on which is in play.
+func TestResumableMergeReaderResume(t *testing.T) {
+	for _, k := range []int{4, tournamentMergeThreshold + 4} {
+		t.Run("k="+strconv.Itoa(k), func(t *testing.T) {
+			ctx := context.Background()
+			rng := rand.New(rand.NewSource(int64(k)))
+			valsPerReader := make([][]int, k)
+			for i := range valsPerReader {
+				vals := make([]int, 200+i*37)
+				for j := range vals {
+					vals[j] = rng.Intn(1 << 20)
+				}
+				sort.Ints(vals)
+				valsPerReader[i] = vals
+			}
+
+			baselineMerge, err := NewResumableMergeReader(ctx, benchTyp, intSorter{}, buildSeekableReaders(valsPerReader))
+			if err != nil {
+				t.Fatal(err)
+			}
+			baseline := drainInts(ctx, t, baselineMerge, -1)
+
+			readers := buildSeekableReaders(valsPerReader)
+			m, err := NewResumableMergeReader(ctx, benchTyp, intSorter{}, readers)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := drainInts(ctx, t, m, len(baseline)/3)
+			cursors := m.Checkpoint()
+
+			// Simulate the readers' worker dying and losing its
+			// in-memory read position.
+			for _, r := range readers {
+				r.(*seekableFrameReader).off = 0
+			}
+			if err := m.Resume(ctx, cursors); err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, drainInts(ctx, t, m, -1)...)
+
+			if !reflect.DeepEqual(got, baseline) {
+				t.Fatalf("resumed merge diverged from baseline: got %d rows, want %d rows", len(got), len(baseline))
+			}
+
+			if seeked, total := m.ResumeStats(); seeked != total {
+				t.Errorf("ResumeStats() = (%d, %d), want all %d readers to take the RowSeeker fast path", seeked, total, total)
+			}
+		})
+	}
+}
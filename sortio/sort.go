@@ -9,63 +9,88 @@ package sortio
 import (
 	"container/heap"
 	"context"
-	"math"
 	"reflect"
 
 	"github.com/grailbio/bigslice/frame"
 	"github.com/grailbio/bigslice/kernel"
+	"github.com/grailbio/bigslice/slicefunc"
 	"github.com/grailbio/bigslice/sliceio"
 	"github.com/grailbio/bigslice/slicetype"
 )
 
-// SortReader sorts a Reader using the provided Sorter. SortReader
-// may spill to disk, in which case it targets spill file sizes of
-// spillTarget (in bytes). Because the encoded size of objects is not
-// known in advance, sortReader uses a "canary" batch size of ~16k
-// rows in order to estimate the size of future reads. The estimate
-// is revisited on every subsequent fill and adjusted if it is
-// violated by more than 5%.
-func SortReader(ctx context.Context, sorter kernel.Sorter, spillTarget int, typ slicetype.Type, r sliceio.Reader) (sliceio.Reader, error) {
+// SortReader sorts a Reader using the provided Sorter, spilling to
+// disk as needed. SortReader buffers rows in a SpillBuffer, picking
+// a sortableBuffer if combiner is slicefunc.Nil, or an
+// appendCombiningBuffer, which reduces rows sharing a key when it
+// is sorted before each spill (not as rows are added), if the slice
+// has a combiner. Each spill's size targets
+// byteBudget bytes; because the encoded size of rows is not known in
+// advance, SortReader starts with a canary batch of
+// sliceio.SpillBatchSize rows and preallocates subsequent spills
+// using a moving average of bytesPerRow observed in prior spills,
+// rather than reallocating the buffer every time the estimate moves.
+func SortReader(ctx context.Context, sorter kernel.Sorter, combiner slicefunc.Func, byteBudget int, typ slicetype.Type, r sliceio.Reader) (sliceio.Reader, error) {
 	spill, err := sliceio.NewSpiller()
 	if err != nil {
 		return nil, err
 	}
 	defer spill.Cleanup()
-	f := frame.Make(typ, 1<<14)
+
+	var buf SpillBuffer
+	if combiner == slicefunc.Nil {
+		buf = newSortableBuffer(spill, typ, sorter)
+	} else {
+		buf = newAppendCombiningBuffer(spill, typ, sorter, combiner)
+	}
+
+	var bytesPerRow float64 = -1
+	rows := sliceio.SpillBatchSize
 	for {
+		buf.Prealloc(rows, byteBudget)
+		f := frame.Make(typ, sliceio.SpillBatchSize)
 		n, err := sliceio.ReadFull(ctx, r, f)
 		if err != nil && err != sliceio.EOF {
 			return nil, err
 		}
 		eof := err == sliceio.EOF
-		g := f.Slice(0, n)
-		sorter.Sort(g)
-		size, err := spill.Spill(g)
+		buf.Add(f.Slice(0, n))
+		if buf.Len() < buf.SizeLimit() && !eof {
+			continue
+		}
+		spilled := buf.Len()
+		buf.Sort()
+		size, err := buf.Spill()
 		if err != nil {
 			return nil, err
 		}
 		if eof {
 			break
 		}
-		bytesPerRow := size / n
-		targetRows := spillTarget / bytesPerRow
-		if targetRows < sliceio.SpillBatchSize {
-			targetRows = sliceio.SpillBatchSize
+		// Update the moving average of bytes per row (seeded by the
+		// first spill, with each later spill weighted 0.3 against 0.7
+		// for the average so far), and reuse it to size the
+		// buffer we preallocate for the next spill.
+		observed := float64(size) / float64(spilled)
+		if bytesPerRow < 0 {
+			bytesPerRow = observed
+		} else {
+			bytesPerRow = 0.7*bytesPerRow + 0.3*observed
 		}
-		// If we're within 5%, that's ok.
-		if math.Abs(float64(f.Len()-targetRows)/float64(targetRows)) > 0.05 {
-			if targetRows <= f.Cap() {
-				f = f.Slice(0, targetRows)
-			} else {
-				f = frame.Make(typ, targetRows)
-			}
+		rows = int(float64(byteBudget) / bytesPerRow)
+		if rows < sliceio.SpillBatchSize {
+			rows = sliceio.SpillBatchSize
 		}
 	}
 	readers, err := spill.Readers()
 	if err != nil {
 		return nil, err
 	}
-	return NewMergeReader(ctx, typ, sorter, readers)
+	// Return a ResumableMergeReader, rather than calling NewMergeReader
+	// or NewTournamentMergeReader directly, so that a task executor
+	// holding the result can checkpoint and resume the merge (via
+	// Checkpoint/Resume) across a transient failure of one of the
+	// spill readers, instead of redoing this entire sort.
+	return NewResumableMergeReader(ctx, typ, sorter, readers)
 }
 
 // A FrameBuffer is a buffered frame. The frame is filled from
@@ -76,6 +101,13 @@ type FrameBuffer struct {
 	Off, Len int
 	Index    int
 	N        int
+	// Source identifies the position of this FrameBuffer's Reader in
+	// the slice of readers it was built from. It is set by
+	// fillFrameBuffers (for ResumableMergeReader and
+	// NewTournamentMergeReader) and used by ResumableMergeReader to
+	// map a buffer back to the reader (and thus the MergeCursor) it
+	// came from; NewMergeReader leaves it unset.
+	Source int
 }
 
 // Fill (re-) fills the FrameBuffer when it's empty. An error
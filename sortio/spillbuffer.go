@@ -0,0 +1,195 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package sortio
+
+import (
+	"github.com/grailbio/bigslice/frame"
+	"github.com/grailbio/bigslice/kernel"
+	"github.com/grailbio/bigslice/slicefunc"
+	"github.com/grailbio/bigslice/sliceio"
+	"github.com/grailbio/bigslice/slicetype"
+)
+
+// A SpillBuffer accumulates rows in memory on behalf of SortReader,
+// which spills it to disk once it has grown to its size limit (or
+// input is exhausted). SpillBuffer lets SortReader's spilling
+// strategy be shared between plain sorting and, for slices with a
+// combiner, an in-memory reduction of the buffered rows.
+type SpillBuffer interface {
+	// Prealloc hints that the buffer should hold approximately rows
+	// rows, totalling bytes bytes, before it is next spilled.
+	// Implementations use this to size their backing storage ahead
+	// of time, rather than growing it as rows are added.
+	Prealloc(rows, bytes int)
+
+	// SizeLimit returns the number of rows the buffer should hold
+	// before the caller sorts and spills it.
+	SizeLimit() int
+
+	// Len returns the number of rows currently resident in the
+	// buffer.
+	Len() int
+
+	// Add buffers the rows in f by appending them. Add does not
+	// combine rows, even in a combining buffer: rows that share a
+	// key stay separate until Sort is called.
+	Add(f frame.Frame)
+
+	// Sort orders the buffer's resident rows so that they can be
+	// spilled as a single sorted run. A combining buffer also
+	// reduces each run of rows that share a key to one row.
+	Sort()
+
+	// Spill writes the buffer's resident rows to its spiller as a
+	// single run, resets the buffer, and returns the encoded size
+	// of the run, in bytes.
+	Spill() (size int, err error)
+}
+
+// sortableBuffer is a SpillBuffer that stores rows contiguously, in
+// the order they are added, and sorts them in place with the
+// provided Sorter just before they are spilled.
+type sortableBuffer struct {
+	spill  *sliceio.Spiller
+	typ    slicetype.Type
+	sorter kernel.Sorter
+
+	buf frame.Frame
+	n   int
+}
+
+func newSortableBuffer(spill *sliceio.Spiller, typ slicetype.Type, sorter kernel.Sorter) *sortableBuffer {
+	return &sortableBuffer{spill: spill, typ: typ, sorter: sorter}
+}
+
+// Prealloc sizes the buffer to hold rows total rows. Any rows
+// already resident are preserved.
+func (b *sortableBuffer) Prealloc(rows, bytes int) {
+	if rows < sliceio.SpillBatchSize {
+		rows = sliceio.SpillBatchSize
+	}
+	if b.buf.IsZero() || b.buf.Cap() < rows {
+		grown := frame.Make(b.typ, rows)
+		grown.Slice(0, b.n).CopyFrom(b.buf.Slice(0, b.n))
+		b.buf = grown
+	}
+}
+
+func (b *sortableBuffer) SizeLimit() int { return b.buf.Cap() }
+func (b *sortableBuffer) Len() int       { return b.n }
+
+func (b *sortableBuffer) Add(f frame.Frame) {
+	if b.buf.IsZero() || b.buf.Cap() < b.n+f.Len() {
+		grown := frame.Make(b.typ, b.n+f.Len())
+		grown.Slice(0, b.n).CopyFrom(b.buf.Slice(0, b.n))
+		b.buf = grown
+	}
+	b.buf.Slice(b.n, b.n+f.Len()).CopyFrom(f)
+	b.n += f.Len()
+}
+
+func (b *sortableBuffer) Sort() { b.sorter.Sort(b.buf.Slice(0, b.n)) }
+
+func (b *sortableBuffer) Spill() (int, error) {
+	size, err := b.spill.Spill(b.buf.Slice(0, b.n))
+	b.n = 0
+	return size, err
+}
+
+// appendCombiningBuffer is a SpillBuffer for slices with a
+// combiner. Add appends rows as they arrive, the same way
+// sortableBuffer does; Sort then sorts the resident rows once and
+// makes a single pass over them, combining each run of rows that
+// share a key (per the Sorter's order) into one row with the
+// combiner function, before the buffer is spilled. Reducing rows
+// this way, rather than probing for a match on every Add, costs
+// O(n log n) for the whole buffer rather than O(n) per row.
+type appendCombiningBuffer struct {
+	spill    *sliceio.Spiller
+	typ      slicetype.Type
+	sorter   kernel.Sorter
+	combiner slicefunc.Func
+
+	buf frame.Frame
+	n   int
+}
+
+func newAppendCombiningBuffer(spill *sliceio.Spiller, typ slicetype.Type, sorter kernel.Sorter, combiner slicefunc.Func) *appendCombiningBuffer {
+	return &appendCombiningBuffer{spill: spill, typ: typ, sorter: sorter, combiner: combiner}
+}
+
+// Prealloc sizes the buffer to hold rows total rows, matching the
+// contract SortReader relies on (rows is its target for the whole
+// spill cycle, not an increment). Any rows already resident are
+// preserved.
+func (b *appendCombiningBuffer) Prealloc(rows, bytes int) {
+	if rows < sliceio.SpillBatchSize {
+		rows = sliceio.SpillBatchSize
+	}
+	if b.buf.IsZero() || b.buf.Cap() < rows {
+		grown := frame.Make(b.typ, rows)
+		grown.Slice(0, b.n).CopyFrom(b.buf.Slice(0, b.n))
+		b.buf = grown
+	}
+}
+
+func (b *appendCombiningBuffer) SizeLimit() int { return b.buf.Cap() }
+func (b *appendCombiningBuffer) Len() int       { return b.n }
+
+// Add appends the rows of f to the buffer, growing its backing
+// storage as needed. Combining happens later, in a single pass, when
+// Sort is called.
+func (b *appendCombiningBuffer) Add(f frame.Frame) {
+	if b.buf.IsZero() || b.buf.Cap() < b.n+f.Len() {
+		grown := frame.Make(b.typ, b.n+f.Len())
+		grown.Slice(0, b.n).CopyFrom(b.buf.Slice(0, b.n))
+		b.buf = grown
+	}
+	b.buf.Slice(b.n, b.n+f.Len()).CopyFrom(f)
+	b.n += f.Len()
+}
+
+// Sort orders the buffer's resident rows with the Sorter, then makes
+// a single compacting pass over them, combining each run of rows
+// that share a key into one row with the combiner.
+func (b *appendCombiningBuffer) Sort() {
+	b.sorter.Sort(b.buf.Slice(0, b.n))
+	b.n = compactEqualRuns(
+		b.n,
+		func(i, j int) bool { return b.sorter.Less(b.buf, i, b.buf, j) },
+		func(dst, src int) { b.combiner.Call(b.buf, dst, b.buf, src) },
+		func(dst, src int) { b.buf.Slice(dst, dst+1).CopyFrom(b.buf.Slice(src, src+1)) },
+	)
+}
+
+// compactEqualRuns scans the sorted half-open range [0, n), combining
+// each run of elements that are equal under less (neither is less
+// than the other) via combine(dst, src), and compacting the
+// surviving elements to the front of the range via copyRow(dst,
+// src). It makes a single pass over the range, so a caller that
+// sorts once and then calls compactEqualRuns pays O(n log n + n)
+// overall, rather than the O(n) cost of probing for (and shifting
+// past) a match on every insertion. It returns the number of rows
+// remaining after combining.
+func compactEqualRuns(n int, less func(i, j int) bool, combine, copyRow func(dst, src int)) int {
+	w := 0
+	for r := 0; r < n; r++ {
+		if w > 0 && !less(w-1, r) && !less(r, w-1) {
+			combine(w-1, r)
+			continue
+		}
+		if w != r {
+			copyRow(w, r)
+		}
+		w++
+	}
+	return w
+}
+
+func (b *appendCombiningBuffer) Spill() (int, error) {
+	size, err := b.spill.Spill(b.buf.Slice(0, b.n))
+	b.n = 0
+	return size, err
+}
@@ -0,0 +1,162 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package sortio
+
+import (
+	"reflect"
+	"strconv"
+	"testing"
+
+	"github.com/grailbio/bigslice/frame"
+	"github.com/grailbio/bigslice/slicefunc"
+	"github.com/grailbio/bigslice/sliceio"
+)
+
+// TestCompactEqualRuns exercises the pure compaction algorithm that
+// appendCombiningBuffer.Sort uses to reduce rows sharing a key,
+// independently of frame.Frame and slicefunc.Func, so the single-pass
+// combine logic itself is covered without needing a real combiner.
+func TestCompactEqualRuns(t *testing.T) {
+	cases := []struct {
+		name string
+		keys []int
+		want []int // surviving values, each the sum of the keys of the run that produced it
+	}{
+		{"empty", nil, nil},
+		{"no duplicates", []int{1, 2, 3}, []int{1, 2, 3}},
+		{"all duplicates", []int{1, 1, 1}, []int{3}},
+		{"run at start", []int{1, 1, 2, 3}, []int{2, 2, 3}},
+		{"run in middle", []int{1, 2, 2, 3}, []int{1, 4, 3}},
+		{"run at end", []int{1, 2, 3, 3}, []int{1, 2, 6}},
+		{"two separate runs", []int{1, 1, 2, 3, 3}, []int{2, 2, 6}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			// Each row has a key, which less compares, and a value,
+			// which combine sums; the value starts out equal to the key.
+			keys := append([]int{}, c.keys...)
+			vals := append([]int{}, c.keys...)
+			less := func(i, j int) bool { return keys[i] < keys[j] }
+			combine := func(dst, src int) { vals[dst] += vals[src] }
+			copyRow := func(dst, src int) { keys[dst], vals[dst] = keys[src], vals[src] }
+
+			n := compactEqualRuns(len(keys), less, combine, copyRow)
+			got := append([]int(nil), vals[:n]...)
+			if !reflect.DeepEqual(got, c.want) {
+				t.Errorf("compactEqualRuns(%v) = %v, want %v", c.keys, got, c.want)
+			}
+		})
+	}
+}
+
+// TestSortableBufferPrealloc is a regression test for
+// sortableBuffer.Prealloc: growing the backing storage must preserve
+// rows already added, the same way appendCombiningBuffer.Prealloc
+// does.
+func TestSortableBufferPrealloc(t *testing.T) {
+	b := newSortableBuffer(nil, benchTyp, intSorter{})
+	b.Add(makeIntFrame(1, 2, 3))
+	if got, want := b.Len(), 3; got != want {
+		t.Fatalf("Len() = %d, want %d", got, want)
+	}
+	b.Prealloc(1024, 0)
+	if got, want := readIntFrame(b.buf, b.n), []int{1, 2, 3}; !reflect.DeepEqual(got, want) {
+		t.Errorf("after Prealloc, resident rows = %v, want %v", got, want)
+	}
+}
+
+// TestSortableBufferAddSort checks that repeated Adds accumulate
+// every row, and that Sort orders them, across a growth boundary.
+func TestSortableBufferAddSort(t *testing.T) {
+	b := newSortableBuffer(nil, benchTyp, intSorter{})
+	b.Add(makeIntFrame(5, 3))
+	b.Add(makeIntFrame(8, 1, 4))
+	b.Sort()
+	if got, want := readIntFrame(b.buf, b.n), []int{1, 3, 4, 5, 8}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+// TestAppendCombiningBufferPrealloc is a regression test for the
+// original Prealloc sizing bug (it compared the buffer's capacity
+// against b.n+rows, a total that grows every Add, instead of rows,
+// the total SortReader actually targets), which caused it to
+// reallocate on essentially every call.
+func TestAppendCombiningBufferPrealloc(t *testing.T) {
+	// Use sliceio.SpillBatchSize itself as the Prealloc target so the
+	// test doesn't depend on its actual magnitude: Prealloc only ever
+	// raises rows up to this floor, never down, so passing it
+	// directly pins the resulting capacity exactly.
+	target := sliceio.SpillBatchSize
+	b := newAppendCombiningBuffer(nil, benchTyp, intSorter{}, slicefunc.Nil)
+	b.Add(makeIntFrame(1, 2, 3))
+	b.Prealloc(target, 0)
+	if got, want := b.buf.Cap(), target; got != want {
+		t.Errorf("Cap() = %d, want %d", got, want)
+	}
+	if got, want := readIntFrame(b.buf, b.n), []int{1, 2, 3}; !reflect.DeepEqual(got, want) {
+		t.Errorf("after Prealloc, resident rows = %v, want %v", got, want)
+	}
+	b.Prealloc(target, 0)
+	if got, want := b.buf.Cap(), target; got != want {
+		t.Errorf("second Prealloc with the same target reallocated: Cap() = %d, want %d", got, want)
+	}
+}
+
+// TestAppendCombiningBufferAddSortNoDuplicates checks Add/Sort with
+// distinct keys, so every row survives unchanged and the combiner is
+// never invoked -- the one appendCombiningBuffer path this trimmed
+// tree can exercise without a real slicefunc.Func (slicefunc has no
+// exported constructor in this tree; see TestCompactEqualRuns above
+// for direct coverage of the combine-on-match path).
+func TestAppendCombiningBufferAddSortNoDuplicates(t *testing.T) {
+	b := newAppendCombiningBuffer(nil, benchTyp, intSorter{}, slicefunc.Nil)
+	b.Add(makeIntFrame(5, 3))
+	b.Add(makeIntFrame(8, 1, 4))
+	b.Sort()
+	if got, want := readIntFrame(b.buf, b.n), []int{1, 3, 4, 5, 8}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+// makeIntFrame builds a single-column int frame.Frame from vals.
+func makeIntFrame(vals ...int) frame.Frame {
+	f := frame.Make(benchTyp, len(vals))
+	row := make([]reflect.Value, 1)
+	for i, v := range vals {
+		row[0] = reflect.ValueOf(v)
+		f.SetIndex(row, i)
+	}
+	return f
+}
+
+// readIntFrame reads back the first n rows of a single-column int
+// frame.Frame.
+func readIntFrame(f frame.Frame, n int) []int {
+	vals := make([]int, n)
+	for i := range vals {
+		vals[i] = intAt(f, i)
+	}
+	return vals
+}
+
+// BenchmarkAppendCombiningBufferAdd measures the cost of Add alone
+// (no Sort), as a function of buffer size: since Add no longer
+// probes for a combine match on every row, it should be flat
+// (amortized O(1) per row) rather than growing with n.
+func BenchmarkAppendCombiningBufferAdd(b *testing.B) {
+	for _, n := range []int{16, 256, 4096} {
+		b.Run("n="+strconv.Itoa(n), func(b *testing.B) {
+			row := makeIntFrame(0)
+			for i := 0; i < b.N; i++ {
+				buf := newAppendCombiningBuffer(nil, benchTyp, intSorter{}, slicefunc.Nil)
+				buf.Prealloc(n, 0)
+				for j := 0; j < n; j++ {
+					buf.Add(row)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,104 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package sortio
+
+import (
+	"context"
+	"reflect"
+
+	"github.com/grailbio/bigslice/frame"
+	"github.com/grailbio/bigslice/kernel"
+	"github.com/grailbio/bigslice/sliceio"
+	"github.com/grailbio/bigslice/slicetype"
+)
+
+// tournamentMergeThreshold is the fan-in at or above which
+// ResumableMergeReader (and so SortReader, which returns one)
+// switches from a container/heap merge to a loserTree. Below this fan-in, the
+// constant factors of the heap implementation make it competitive,
+// and it is simpler.
+const tournamentMergeThreshold = 16
+
+// tournamentMergeReader merges multiple (sorted) readers using a
+// loserTree. This pays off when the fan-in is large (hundreds of
+// spill files or shuffle inputs) or when Sorter.Less is expensive
+// (e.g. multi-column string keys), compared to mergeReader's
+// container/heap, which costs up to 2*log2(k) comparisons per row
+// rather than the loser tree's ceil(log2(k)).
+type tournamentMergeReader struct {
+	tree *loserTree
+	err  error
+}
+
+// NewTournamentMergeReader returns a Reader, sorted according to
+// sorter, that merges readers using a loser tree. The readers to be
+// merged must already be sorted according to the same Sorter.
+func NewTournamentMergeReader(ctx context.Context, typ slicetype.Type, sorter kernel.Sorter, readers []sliceio.Reader) (sliceio.Reader, error) {
+	buffers, err := fillFrameBuffers(ctx, typ, readers)
+	if err != nil {
+		return nil, err
+	}
+	return &tournamentMergeReader{tree: newLoserTree(sorter, buffers)}, nil
+}
+
+// fillFrameBuffers builds one FrameBuffer per reader, each tagged
+// with its position in readers via Source, and fills it with its
+// first batch of rows. A reader that is immediately exhausted
+// contributes a nil entry.
+func fillFrameBuffers(ctx context.Context, typ slicetype.Type, readers []sliceio.Reader) ([]*FrameBuffer, error) {
+	buffers := make([]*FrameBuffer, len(readers))
+	for i, r := range readers {
+		fr := &FrameBuffer{
+			Reader: r,
+			Frame:  frame.Make(typ, sliceio.SpillBatchSize),
+			Source: i,
+		}
+		switch err := fr.Fill(ctx); {
+		case err == sliceio.EOF:
+			// No data: leave this entry nil.
+		case err != nil:
+			return nil, err
+		default:
+			buffers[i] = fr
+		}
+	}
+	return buffers, nil
+}
+
+// Read implements Reader.
+func (m *tournamentMergeReader) Read(ctx context.Context, out frame.Frame) (int, error) {
+	if m.err != nil {
+		return 0, m.err
+	}
+	var (
+		row = make([]reflect.Value, len(out))
+		n   int
+		max = out.Len()
+	)
+	for n < max {
+		w := m.tree.winner()
+		buf := m.tree.buffers[w]
+		if buf == nil {
+			break
+		}
+		buf.CopyIndex(row, buf.Off)
+		out.SetIndex(row, n)
+		n++
+		buf.Off++
+		if buf.Off == buf.Len {
+			if err := buf.Fill(ctx); err != nil && err != sliceio.EOF {
+				m.err = err
+				return 0, err
+			} else if err == sliceio.EOF {
+				m.tree.buffers[w] = nil
+			}
+		}
+		m.tree.replay(w)
+	}
+	if n == 0 {
+		m.err = sliceio.EOF
+	}
+	return n, m.err
+}
@@ -0,0 +1,188 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package sortio
+
+import (
+	"context"
+	"math/rand"
+	"reflect"
+	"sort"
+	"strconv"
+	"testing"
+
+	"github.com/grailbio/bigslice/frame"
+	"github.com/grailbio/bigslice/sliceio"
+	"github.com/grailbio/bigslice/slicetype"
+)
+
+var benchTyp = slicetype.New(reflect.TypeOf(0))
+
+// intSorter sorts and compares frames with a single int column. It
+// is used by the benchmarks below to compare NewMergeReader and
+// NewTournamentMergeReader independently of a real Sorter's cost.
+type intSorter struct{}
+
+func (intSorter) Sort(f frame.Frame) { sort.Sort(intFrame{f}) }
+
+func (intSorter) Less(f1 frame.Frame, i int, f2 frame.Frame, j int) bool {
+	return intAt(f1, i) < intAt(f2, j)
+}
+
+func intAt(f frame.Frame, i int) int {
+	row := make([]reflect.Value, 1)
+	f.CopyIndex(row, i)
+	return int(row[0].Int())
+}
+
+// intFrame adapts a single-column int frame.Frame to sort.Interface.
+type intFrame struct{ frame.Frame }
+
+func (f intFrame) Len() int { return f.Frame.Len() }
+
+func (f intFrame) Less(i, j int) bool { return intAt(f.Frame, i) < intAt(f.Frame, j) }
+
+func (f intFrame) Swap(i, j int) {
+	ri, rj := make([]reflect.Value, 1), make([]reflect.Value, 1)
+	f.Frame.CopyIndex(ri, i)
+	f.Frame.CopyIndex(rj, j)
+	f.Frame.SetIndex(rj, i)
+	f.Frame.SetIndex(ri, j)
+}
+
+// frameReader is a sliceio.Reader over an in-memory frame.Frame. It
+// is used to hand the mergers below already-sorted input, as a real
+// spill reader would.
+type frameReader struct {
+	frame.Frame
+	off int
+}
+
+func (r *frameReader) Read(ctx context.Context, out frame.Frame) (int, error) {
+	if r.off >= r.Frame.Len() {
+		return 0, sliceio.EOF
+	}
+	n := out.Len()
+	if m := r.Frame.Len() - r.off; m < n {
+		n = m
+	}
+	row := make([]reflect.Value, 1)
+	for i := 0; i < n; i++ {
+		r.Frame.CopyIndex(row, r.off+i)
+		out.SetIndex(row, i)
+	}
+	r.off += n
+	return n, nil
+}
+
+// makeSortedReaders builds k readers, together holding rows sorted
+// int values split evenly across them, each individually sorted, as
+// NewMergeReader and NewTournamentMergeReader both require of their
+// input.
+func makeSortedReaders(k, rows int) []sliceio.Reader {
+	readers := make([]sliceio.Reader, k)
+	for i := range readers {
+		n := rows / k
+		vals := make([]int, n)
+		for j := range vals {
+			vals[j] = rand.Intn(1 << 30)
+		}
+		sort.Ints(vals)
+		f := frame.Make(benchTyp, n)
+		row := make([]reflect.Value, 1)
+		for j, v := range vals {
+			row[0] = reflect.ValueOf(v)
+			f.SetIndex(row, j)
+		}
+		readers[i] = &frameReader{Frame: f}
+	}
+	return readers
+}
+
+func drain(ctx context.Context, tb testing.TB, r sliceio.Reader) {
+	out := frame.Make(benchTyp, 4096)
+	for {
+		if _, err := r.Read(ctx, out); err != nil {
+			if err == sliceio.EOF {
+				return
+			}
+			tb.Fatal(err)
+		}
+	}
+}
+
+// TestTournamentMergeReader checks NewTournamentMergeReader's output
+// against a reference sort (and against NewMergeReader) at
+// non-power-of-two fan-ins, and with readers of uneven length,
+// including zero-length readers.
+func TestTournamentMergeReader(t *testing.T) {
+	ctx := context.Background()
+	for _, k := range []int{3, 5, 17} {
+		t.Run("k="+strconv.Itoa(k), func(t *testing.T) {
+			rng := rand.New(rand.NewSource(int64(k)))
+			valsPerReader := make([][]int, k)
+			var want []int
+			for i := range valsPerReader {
+				n := i * 7 // uneven lengths; i==0 is a zero-length reader.
+				vals := make([]int, n)
+				for j := range vals {
+					vals[j] = rng.Intn(1 << 20)
+				}
+				sort.Ints(vals)
+				valsPerReader[i] = vals
+				want = append(want, vals...)
+			}
+			sort.Ints(want)
+
+			heapMerge, err := NewMergeReader(ctx, benchTyp, intSorter{}, buildSeekableReaders(valsPerReader))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := drainInts(ctx, t, heapMerge, -1); !reflect.DeepEqual(got, want) {
+				t.Errorf("NewMergeReader: got %v, want %v", got, want)
+			}
+
+			tournamentMerge, err := NewTournamentMergeReader(ctx, benchTyp, intSorter{}, buildSeekableReaders(valsPerReader))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := drainInts(ctx, t, tournamentMerge, -1); !reflect.DeepEqual(got, want) {
+				t.Errorf("NewTournamentMergeReader: got %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+// BenchmarkMergeReader compares the container/heap-based
+// NewMergeReader against the loser-tree NewTournamentMergeReader at
+// increasing fan-in.
+func BenchmarkMergeReader(b *testing.B) {
+	ctx := context.Background()
+	for _, k := range []int{4, 16, 64, 256} {
+		b.Run("heap/k="+strconv.Itoa(k), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				readers := makeSortedReaders(k, 4096)
+				b.StartTimer()
+				r, err := NewMergeReader(ctx, benchTyp, intSorter{}, readers)
+				if err != nil {
+					b.Fatal(err)
+				}
+				drain(ctx, b, r)
+			}
+		})
+		b.Run("tournament/k="+strconv.Itoa(k), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				readers := makeSortedReaders(k, 4096)
+				b.StartTimer()
+				r, err := NewTournamentMergeReader(ctx, benchTyp, intSorter{}, readers)
+				if err != nil {
+					b.Fatal(err)
+				}
+				drain(ctx, b, r)
+			}
+		})
+	}
+}